@@ -0,0 +1,65 @@
+package sup
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// buildBastionChain dials each bastion host in order through the previous
+// hop's DialThrough, mirroring OpenSSH's -J/ProxyJump chaining. The last
+// entry is the hop callers dial the real targets through.
+func buildBastionChain(hosts []*Host, password string, hostKeyPolicy HostKeyPolicy) ([]*SSHClient, error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+
+	var chain []*SSHClient
+	for i, host := range hosts {
+		hop := &SSHClient{
+			host:          host,
+			password:      password,
+			hostKeyPolicy: hostKeyPolicy,
+		}
+
+		var err error
+		if i == 0 {
+			err = hop.Connect()
+		} else {
+			err = hop.ConnectWith(chain[i-1].DialThrough)
+		}
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				chain[j].Close()
+			}
+			return nil, errors.Wrapf(err, "connecting to bastion hop %d (%s) failed", i+1, host.Hostname)
+		}
+
+		chain = append(chain, hop)
+	}
+
+	return chain, nil
+}
+
+// parseProxyJump turns an OpenSSH-style `-J` chain ("user@jump1,user@jump2")
+// into the ordered list of bastion hosts to hop through.
+func parseProxyJump(proxyJump string) []*Host {
+	if proxyJump == "" {
+		return nil
+	}
+
+	var hosts []*Host
+	for _, hop := range strings.Split(proxyJump, ",") {
+		hop = strings.TrimSpace(hop)
+		if hop == "" {
+			continue
+		}
+
+		user, hostname := "", hop
+		if idx := strings.Index(hop, "@"); idx >= 0 {
+			user, hostname = hop[:idx], hop[idx+1:]
+		}
+		hosts = append(hosts, &Host{User: user, Hostname: hostname})
+	}
+	return hosts
+}
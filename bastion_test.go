@@ -0,0 +1,44 @@
+package sup
+
+import "testing"
+
+func TestParseProxyJumpEmpty(t *testing.T) {
+	if hosts := parseProxyJump(""); hosts != nil {
+		t.Fatalf("expected nil hosts for an empty ProxyJump, got %v", hosts)
+	}
+}
+
+func TestParseProxyJumpSingleHop(t *testing.T) {
+	hosts := parseProxyJump("jump1")
+	if len(hosts) != 1 {
+		t.Fatalf("expected 1 hop, got %d", len(hosts))
+	}
+	if hosts[0].User != "" || hosts[0].Hostname != "jump1" {
+		t.Fatalf("unexpected hop: %+v", hosts[0])
+	}
+}
+
+func TestParseProxyJumpMultiHop(t *testing.T) {
+	hosts := parseProxyJump("alice@jump1,bob@jump2, jump3")
+	if len(hosts) != 3 {
+		t.Fatalf("expected 3 hops, got %d: %+v", len(hosts), hosts)
+	}
+
+	want := []*Host{
+		{User: "alice", Hostname: "jump1"},
+		{User: "bob", Hostname: "jump2"},
+		{User: "", Hostname: "jump3"},
+	}
+	for i, w := range want {
+		if hosts[i].User != w.User || hosts[i].Hostname != w.Hostname {
+			t.Fatalf("hop %d = %+v, want %+v", i, hosts[i], w)
+		}
+	}
+}
+
+func TestParseProxyJumpSkipsEmptyHops(t *testing.T) {
+	hosts := parseProxyJump("jump1,,jump2")
+	if len(hosts) != 2 {
+		t.Fatalf("expected empty hops to be skipped, got %d: %+v", len(hosts), hosts)
+	}
+}
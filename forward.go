@@ -0,0 +1,99 @@
+package sup
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RemoteForward declares a reverse (remote->local) port forward, like
+// `ssh -R`: connections accepted on Remote (listened on the remote host)
+// are proxied to Local. Declare it on a Network to apply to every task, or
+// on a Command to scope it to one.
+type RemoteForward struct {
+	Remote string
+	Local  string
+}
+
+// remoteForwarder owns the listener and accept loop for one RemoteForward.
+// wg tracks the accept loop and every proxyForward goroutine it spawns, so
+// Close can wait for them to drain.
+type remoteForwarder struct {
+	listener net.Listener
+	closed   chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// startRemoteForward asks the remote host to listen on fw.Remote and
+// proxies every connection it accepts there to fw.Local.
+func startRemoteForward(remote *SSHClient, fw RemoteForward, logger Logger) (*remoteForwarder, error) {
+	listener, err := remote.client.Listen("tcp", fw.Remote)
+	if err != nil {
+		return nil, errors.Wrapf(err, "remote listen on %s failed", fw.Remote)
+	}
+
+	rf := &remoteForwarder{listener: listener, closed: make(chan struct{})}
+
+	rf.wg.Add(1)
+	go func() {
+		defer rf.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-rf.closed:
+					return // Listener closed during teardown; not an error.
+				default:
+					logger.Warnf("%v", errors.Wrapf(err, "accepting forward on %s failed", fw.Remote))
+					return
+				}
+			}
+			rf.wg.Add(1)
+			go func() {
+				defer rf.wg.Done()
+				proxyForward(conn, fw.Local, logger)
+			}()
+		}
+	}()
+
+	return rf, nil
+}
+
+func proxyForward(remoteConn net.Conn, local string, logger Logger) {
+	defer remoteConn.Close()
+
+	localConn, err := net.Dial("tcp", local)
+	if err != nil {
+		logger.Warnf("%v", errors.Wrapf(err, "dialing local forward target %s failed", local))
+		return
+	}
+	defer localConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(localConn, remoteConn)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(remoteConn, localConn)
+	}()
+	wg.Wait()
+}
+
+// Close stops the accept loop and waits for every in-flight connection to
+// finish before returning. Safe to call more than once; only the first
+// call closes the listener, but every call waits for the drain.
+func (rf *remoteForwarder) Close() error {
+	var err error
+	rf.once.Do(func() {
+		close(rf.closed)
+		err = rf.listener.Close()
+	})
+	rf.wg.Wait()
+	return err
+}
@@ -0,0 +1,108 @@
+package sup
+
+import (
+	"bytes"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyPolicy replaces the previous all-or-nothing IgnoreHostKey(true)
+// escape hatch with four explicit verification modes. SSHClient.Connect
+// turns it into an ssh.HostKeyCallback via hostKeyCallback.
+type HostKeyPolicy string
+
+const (
+	// HostKeyStrict requires the host key to already be present in
+	// known_hosts and to match; it's the default.
+	HostKeyStrict HostKeyPolicy = "strict"
+	// HostKeyTOFU ("trust on first use") accepts and records any host key
+	// not yet in known_hosts, but hard-fails on a later mismatch.
+	HostKeyTOFU HostKeyPolicy = "tofu"
+	// HostKeyAcceptNew behaves like HostKeyTOFU; it's the name OpenSSH
+	// itself uses for the same behavior (StrictHostKeyChecking=accept-new).
+	HostKeyAcceptNew HostKeyPolicy = "accept-new"
+	// HostKeyIgnore skips verification entirely, matching the previous
+	// IgnoreHostKey(true) behavior.
+	HostKeyIgnore HostKeyPolicy = "ignore"
+)
+
+// hostKeyCallback builds the ssh.HostKeyCallback for host. A pinned
+// host_key takes precedence over policy; a per-host known_hosts path
+// replaces ~/.ssh/known_hosts for every policy except "ignore".
+func hostKeyCallback(policy HostKeyPolicy, host *Host) (ssh.HostKeyCallback, error) {
+	if host.HostKey != "" {
+		pinned, _, _, _, err := ssh.ParseAuthorizedKey([]byte(host.HostKey))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing host_key for %s failed", host.Hostname)
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if !bytes.Equal(pinned.Marshal(), key.Marshal()) {
+				return errors.Errorf("host key mismatch for %s: expected %s, got %s",
+					hostname, ssh.FingerprintSHA256(pinned), ssh.FingerprintSHA256(key))
+			}
+			return nil
+		}, nil
+	}
+
+	if policy == HostKeyIgnore {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	path := host.KnownHosts
+	if path == "" {
+		path = ResolvePath("~/.ssh/known_hosts")
+	}
+
+	strictCallback, err := knownhosts.New(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "reading known_hosts %s failed", path)
+		}
+		if policy != HostKeyTOFU && policy != HostKeyAcceptNew {
+			return nil, errors.Wrapf(err, "reading known_hosts %s failed", path)
+		}
+		// No known_hosts file yet: every host is "unknown", which is
+		// exactly what TOFU/accept-new needs to add the first entry.
+		strictCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}
+	}
+
+	if policy != HostKeyTOFU && policy != HostKeyAcceptNew {
+		return strictCallback, nil
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := strictCallback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		if keyErr, ok := err.(*knownhosts.KeyError); ok && len(keyErr.Want) > 0 {
+			// The host is known under a *different* key: a real mismatch,
+			// not first contact. Fail hard with the offending fingerprint.
+			return errors.Errorf("host key mismatch for %s: known_hosts has %s, remote offered %s",
+				hostname, ssh.FingerprintSHA256(keyErr.Want[0].Key), ssh.FingerprintSHA256(key))
+		}
+
+		// Unknown host: accept and record it (trust on first use).
+		return appendKnownHost(path, hostname, key)
+	}, nil
+}
+
+// appendKnownHost records a newly trusted host key so future connections
+// verify against it instead of re-trusting on every run.
+func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "opening known_hosts %s failed", path)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(knownhosts.Line([]string{hostname}, key) + "\n")
+	return err
+}
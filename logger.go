@@ -0,0 +1,49 @@
+package sup
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is the interface Stackup uses for all diagnostic output, so
+// callers embedding Sup can redirect, filter, or structure its logs.
+// SetLogger installs a custom implementation; New defaults to a
+// logrus-backed one.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// logrusLogger is the default Logger; its level is driven by
+// Stackup.Debug().
+type logrusLogger struct {
+	log *logrus.Logger
+}
+
+func newDefaultLogger(debug bool) *logrusLogger {
+	log := logrus.New()
+	log.Out = os.Stderr
+	log.Level = logrus.InfoLevel
+	if debug {
+		log.Level = logrus.DebugLevel
+	}
+	return &logrusLogger{log: log}
+}
+
+func (l *logrusLogger) Debugf(format string, args ...interface{}) { l.log.Debugf(format, args...) }
+func (l *logrusLogger) Infof(format string, args ...interface{})  { l.log.Infof(format, args...) }
+func (l *logrusLogger) Warnf(format string, args ...interface{})  { l.log.Warnf(format, args...) }
+func (l *logrusLogger) Errorf(format string, args ...interface{}) { l.log.Errorf(format, args...) }
+
+// SetLevel adjusts verbosity at runtime, e.g. from --verbose/--quiet flags.
+func (l *logrusLogger) SetLevel(level logrus.Level) {
+	l.log.Level = level
+}
+
+// SetLogger installs a custom Logger, overriding the logrus-backed default.
+func (sup *Stackup) SetLogger(l Logger) {
+	sup.logger = l
+}
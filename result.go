@@ -0,0 +1,108 @@
+package sup
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// OutputFormat selects how Stackup reports command output and host status.
+//
+// "text" (the default) streams raw, prefixed stdout/stderr to the terminal,
+// matching Sup's historical behavior. "json" and "ndjson" instead buffer
+// each command's output and emit one structured CommandResult record per
+// command per host, so CI systems (Drone, Woodpecker, ...) can parse Sup's
+// output programmatically. "json" and "ndjson" currently behave the same:
+// one JSON object per line, newline-delimited.
+type OutputFormat string
+
+const (
+	OutputText   OutputFormat = "text"
+	OutputJSON   OutputFormat = "json"
+	OutputNDJSON OutputFormat = "ndjson"
+)
+
+// CommandResult is one structured record describing a single command run on
+// a single host.
+type CommandResult struct {
+	Host      string    `json:"host"`
+	Task      string    `json:"task"`
+	Command   string    `json:"command"`
+	Stdout    string    `json:"stdout"`
+	Stderr    string    `json:"stderr"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	ExitCode  int       `json:"exit_code"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// HostStatus summarizes the outcome of a single host within a Result,
+// including hosts a rolling Strategy never got to run.
+type HostStatus string
+
+const (
+	HostSucceeded HostStatus = "succeeded"
+	HostFailed    HostStatus = "failed"
+	HostSkipped   HostStatus = "skipped"
+)
+
+// HostResult aggregates every CommandResult produced on one host during a
+// Run.
+type HostResult struct {
+	Host     string           `json:"host"`
+	Status   HostStatus       `json:"status"`
+	Commands []*CommandResult `json:"commands"`
+}
+
+// Failed reports whether any command run on this host exited non-zero.
+func (hr *HostResult) Failed() bool {
+	for _, cmd := range hr.Commands {
+		if cmd.ExitCode != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is returned by Stackup.Run once every host has finished (or been
+// skipped), replacing the previous practice of os.Exit()-ing on the first
+// failure. Callers decide when and how to exit based on Result.ExitCode().
+type Result struct {
+	Hosts []*HostResult `json:"hosts"`
+}
+
+// ExitCode returns the highest exit status seen across all hosts, or 0 if
+// every host succeeded.
+func (r *Result) ExitCode() int {
+	code := 0
+	for _, hr := range r.Hosts {
+		for _, cmd := range hr.Commands {
+			if cmd.ExitCode > code {
+				code = cmd.ExitCode
+			}
+		}
+	}
+	return code
+}
+
+// recordEncoder writes CommandResult records to w as they complete, one
+// JSON object per line (NDJSON), regardless of whether OutputJSON or
+// OutputNDJSON was selected. Encode is called concurrently from every
+// host's goroutine under the default "parallel" Strategy, so it guards the
+// underlying json.Encoder with a mutex; json.Encoder isn't safe for
+// concurrent use on its own.
+type recordEncoder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newRecordEncoder(w io.Writer) *recordEncoder {
+	return &recordEncoder{enc: json.NewEncoder(w)}
+}
+
+func (re *recordEncoder) Encode(cmd *CommandResult) error {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	return re.enc.Encode(cmd)
+}
@@ -0,0 +1,69 @@
+package sup
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestResultExitCode(t *testing.T) {
+	result := &Result{
+		Hosts: []*HostResult{
+			{Host: "a", Commands: []*CommandResult{{ExitCode: 0}}},
+			{Host: "b", Commands: []*CommandResult{{ExitCode: 2}, {ExitCode: 1}}},
+			{Host: "c", Status: HostSkipped},
+		},
+	}
+	if code := result.ExitCode(); code != 2 {
+		t.Fatalf("ExitCode() = %d, want 2", code)
+	}
+}
+
+func TestResultExitCodeAllSucceeded(t *testing.T) {
+	result := &Result{
+		Hosts: []*HostResult{
+			{Host: "a", Commands: []*CommandResult{{ExitCode: 0}}},
+		},
+	}
+	if code := result.ExitCode(); code != 0 {
+		t.Fatalf("ExitCode() = %d, want 0", code)
+	}
+}
+
+func TestHostResultFailed(t *testing.T) {
+	succeeded := &HostResult{Commands: []*CommandResult{{ExitCode: 0}}}
+	if succeeded.Failed() {
+		t.Fatal("expected a host with only zero exit codes to not be Failed")
+	}
+
+	failed := &HostResult{Commands: []*CommandResult{{ExitCode: 0}, {ExitCode: 1}}}
+	if !failed.Failed() {
+		t.Fatal("expected a host with a non-zero exit code to be Failed")
+	}
+}
+
+func TestRecordEncoderWritesNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	re := newRecordEncoder(&buf)
+
+	if err := re.Encode(&CommandResult{Host: "a", Task: "deploy", ExitCode: 0}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if err := re.Encode(&CommandResult{Host: "b", Task: "deploy", ExitCode: 1}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+
+	var first CommandResult
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("unmarshaling first record failed: %v", err)
+	}
+	if first.Host != "a" || first.ExitCode != 0 {
+		t.Fatalf("unexpected first record: %+v", first)
+	}
+}
@@ -0,0 +1,197 @@
+package sup
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+)
+
+// Transfer describes a single upload or download declared on a Command via
+// the `upload:`/`download:` Supfile keys (alongside `run:`/`script:`), e.g.:
+//
+//	upload:
+//	  src: ./dist/
+//	  dst: /opt/app/
+//	  mode: 0755
+//
+// Every file is written unconditionally on each run; there's no
+// size/mtime/hash comparison or resume.
+type Transfer struct {
+	Src  string
+	Dst  string
+	Mode os.FileMode
+}
+
+// clientError pairs a Client with the error it produced, so a failure can be
+// attributed back to the host it happened on.
+type clientError struct {
+	client Client
+	err    error
+}
+
+// runTransfer fans out concurrent SFTP transfers to every SSH client,
+// reusing each client's already-authenticated connection. A transfer
+// failure is returned keyed by the client it happened on.
+func (sup *Stackup) runTransfer(t *Transfer, upload bool, clients []Client) map[Client]error {
+	var wg sync.WaitGroup
+	errCh := make(chan clientError, len(clients))
+
+	for _, c := range clients {
+		remote, ok := c.(*SSHClient)
+		if !ok {
+			// upload:/download: targets remote hosts; localhost has no
+			// SFTP session to open.
+			continue
+		}
+
+		wg.Add(1)
+		go func(c Client, remote *SSHClient) {
+			defer wg.Done()
+
+			sc, err := sftp.NewClient(remote.client)
+			if err != nil {
+				errCh <- clientError{c, errors.Wrap(err, "opening SFTP session failed")}
+				return
+			}
+			defer sc.Close()
+
+			prefix, _ := remote.Prefix()
+
+			var transferErr error
+			if upload {
+				transferErr = sftpUpload(sc, t, prefix, sup.logger)
+			} else {
+				transferErr = sftpDownload(sc, t, prefix, sup.logger)
+			}
+			if transferErr != nil {
+				errCh <- clientError{c, errors.Wrap(transferErr, prefix+"transfer failed")}
+			}
+		}(c, remote)
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	errs := make(map[Client]error)
+	for ce := range errCh {
+		errs[ce.client] = ce.err
+	}
+	return errs
+}
+
+// sftpUpload copies t.Src (a file or directory) from the local filesystem
+// to t.Dst on the remote host.
+func sftpUpload(sc *sftp.Client, t *Transfer, prefix string, logger Logger) error {
+	info, err := os.Stat(t.Src)
+	if err != nil {
+		return errors.Wrap(err, "stat local path failed")
+	}
+
+	if !info.IsDir() {
+		return uploadFile(sc, t.Src, t.Dst, t.Mode, prefix, logger)
+	}
+
+	return filepath.Walk(t.Src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(t.Src, path)
+		if err != nil {
+			return err
+		}
+		return uploadFile(sc, path, filepath.Join(t.Dst, rel), t.Mode, prefix, logger)
+	})
+}
+
+func uploadFile(sc *sftp.Client, src, dst string, mode os.FileMode, prefix string, logger Logger) error {
+	local, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	if err := sc.MkdirAll(filepath.Dir(dst)); err != nil {
+		return errors.Wrap(err, "creating remote directory failed")
+	}
+
+	remote, err := sc.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	if _, err := remote.ReadFrom(local); err != nil {
+		return err
+	}
+
+	if mode != 0 {
+		if err := sc.Chmod(dst, mode); err != nil {
+			return errors.Wrap(err, "chmod failed")
+		}
+	}
+
+	logger.Infof("%s%s -> %s", prefix, src, dst)
+	return nil
+}
+
+// sftpDownload copies t.Src on the remote host to t.Dst on the local
+// filesystem.
+func sftpDownload(sc *sftp.Client, t *Transfer, prefix string, logger Logger) error {
+	info, err := sc.Stat(t.Src)
+	if err != nil {
+		return errors.Wrap(err, "stat remote path failed")
+	}
+
+	if !info.IsDir() {
+		return downloadFile(sc, t.Src, t.Dst, prefix, logger)
+	}
+
+	walker := sc.Walk(t.Src)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		if walker.Stat().IsDir() {
+			continue
+		}
+		rel, err := filepath.Rel(t.Src, walker.Path())
+		if err != nil {
+			return err
+		}
+		if err := downloadFile(sc, walker.Path(), filepath.Join(t.Dst, rel), prefix, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downloadFile(sc *sftp.Client, src, dst, prefix string, logger Logger) error {
+	remote, err := sc.Open(src)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	local, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	if _, err := remote.WriteTo(local); err != nil {
+		return err
+	}
+
+	logger.Infof("%s%s -> %s", prefix, src, dst)
+	return nil
+}
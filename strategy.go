@@ -0,0 +1,45 @@
+package sup
+
+// Strategy controls how Stackup.Run schedules hosts within a Network.
+//
+//	parallel (default) - connect to and run on every host at once.
+//	serial             - run on one host at a time.
+//	rolling            - run on BatchSize hosts at a time, waiting for each
+//	                      wave to finish before promoting the next, and
+//	                      aborting once MaxFailures is reached.
+type Strategy string
+
+const (
+	StrategyParallel Strategy = "parallel"
+	StrategySerial   Strategy = "serial"
+	StrategyRolling  Strategy = "rolling"
+)
+
+// batchHosts splits hosts into waves according to strategy. Unset/parallel
+// runs every host in one wave; serial is rolling with batch size 1; rolling
+// honors batchSize, defaulting to 1 when unset.
+func batchHosts(hosts []*Host, strategy Strategy, batchSize int) [][]*Host {
+	switch strategy {
+	case StrategySerial:
+		batchSize = 1
+	case StrategyRolling:
+		if batchSize <= 0 {
+			batchSize = 1
+		}
+	default: // StrategyParallel, or unset.
+		if len(hosts) == 0 {
+			return nil
+		}
+		return [][]*Host{hosts}
+	}
+
+	var batches [][]*Host
+	for i := 0; i < len(hosts); i += batchSize {
+		end := i + batchSize
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		batches = append(batches, hosts[i:end])
+	}
+	return batches
+}
@@ -0,0 +1,88 @@
+package sup
+
+import "testing"
+
+func hostsNamed(names ...string) []*Host {
+	hosts := make([]*Host, len(names))
+	for i, name := range names {
+		hosts[i] = &Host{Hostname: name}
+	}
+	return hosts
+}
+
+func batchNames(t *testing.T, batches [][]*Host) [][]string {
+	t.Helper()
+	names := make([][]string, len(batches))
+	for i, batch := range batches {
+		for _, host := range batch {
+			names[i] = append(names[i], host.Hostname)
+		}
+	}
+	return names
+}
+
+func TestBatchHostsParallel(t *testing.T) {
+	hosts := hostsNamed("a", "b", "c")
+
+	batches := batchHosts(hosts, StrategyParallel, 0)
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expected a single batch of 3 hosts, got %v", batchNames(t, batches))
+	}
+
+	// Unset Strategy behaves the same as explicit "parallel".
+	if batches := batchHosts(hosts, "", 0); len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expected unset strategy to default to parallel, got %v", batchNames(t, batches))
+	}
+
+	if batches := batchHosts(nil, StrategyParallel, 0); batches != nil {
+		t.Fatalf("expected no batches for an empty host list, got %v", batches)
+	}
+}
+
+func TestBatchHostsSerial(t *testing.T) {
+	hosts := hostsNamed("a", "b", "c")
+
+	// Serial always runs one host per wave, regardless of batchSize.
+	batches := batchHosts(hosts, StrategySerial, 10)
+	want := [][]string{{"a"}, {"b"}, {"c"}}
+	got := batchNames(t, batches)
+	if !equalBatches(got, want) {
+		t.Fatalf("batchHosts(serial) = %v, want %v", got, want)
+	}
+}
+
+func TestBatchHostsRolling(t *testing.T) {
+	hosts := hostsNamed("a", "b", "c", "d", "e")
+
+	batches := batchHosts(hosts, StrategyRolling, 2)
+	want := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	got := batchNames(t, batches)
+	if !equalBatches(got, want) {
+		t.Fatalf("batchHosts(rolling, 2) = %v, want %v", got, want)
+	}
+
+	// An unset batch size defaults to one, same as serial.
+	batches = batchHosts(hosts, StrategyRolling, 0)
+	want = [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}}
+	got = batchNames(t, batches)
+	if !equalBatches(got, want) {
+		t.Fatalf("batchHosts(rolling, 0) = %v, want %v", got, want)
+	}
+}
+
+func equalBatches(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -1,7 +1,7 @@
 package sup
 
 import (
-	"fmt"
+	"bytes"
 	"io"
 	"io/ioutil"
 	"net"
@@ -11,9 +11,12 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/goware/prefixer"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
@@ -24,12 +27,19 @@ type Stackup struct {
 	conf          *Supfile
 	debug         bool
 	prefix        bool
-	ignoreHostKey bool
+	hostKeyPolicy HostKeyPolicy
+	format        OutputFormat
+	output        io.Writer
+	logger        Logger
 }
 
 func New(conf *Supfile) (*Stackup, error) {
 	return &Stackup{
-		conf: conf,
+		conf:          conf,
+		format:        OutputText,
+		output:        os.Stdout,
+		logger:        newDefaultLogger(false),
+		hostKeyPolicy: HostKeyStrict,
 	}, nil
 }
 
@@ -70,12 +80,30 @@ func addPublicKeySigner(file string, password string) error {
 	return nil
 }
 
-// Run runs set of commands on multiple hosts defined by network sequentially.
+// Run runs set of commands on multiple hosts defined by network. It no
+// longer os.Exit()s on the first failure: a host's failure, at any point, is
+// folded into its own HostResult instead of aborting the rest, and the
+// aggregated *Result is returned for the caller to inspect via
+// Result.ExitCode(). When sup.format is OutputJSON or OutputNDJSON, one
+// CommandResult record is also streamed to sup.output as each command
+// finishes.
+//
+// Hosts are scheduled according to network.Strategy: "parallel" (default)
+// runs every host at once; "serial" processes them one at a time and
+// "rolling" in waves of network.BatchSize (1 when unset), aborting once
+// network.MaxFailures is reached and marking any unprocessed hosts
+// HostSkipped.
 // TODO: This megamoth method needs a big refactor and should be split
 //       to multiple smaller methods.
-func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command) error {
+func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command) (*Result, error) {
 	if len(commands) == 0 {
-		return errors.New("no commands to be run")
+		return nil, errors.New("no commands to be run")
+	}
+
+	structured := sup.format == OutputJSON || sup.format == OutputNDJSON
+	var recorder *recordEncoder
+	if structured {
+		recorder = newRecordEncoder(sup.output)
 	}
 
 	env := envVars.AsExport()
@@ -90,7 +118,7 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 	if network.IdentityFile != "" {
 		err := addPublicKeySigner(network.IdentityFile, network.Password)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: %s Encrypted Key? (network: %s identity_file: %s)\n", err, network.Name, network.IdentityFile)
+			sup.logger.Warnf("%s Encrypted Key? (network: %s identity_file: %s)", err, network.Name, network.IdentityFile)
 		}
 	} else {
 		// Try to read user's SSH private keys form the standard paths.
@@ -103,24 +131,119 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 		}
 	}
 
-	// Create clients for every host (either SSH or Localhost).
+	// The bastion chain, if any, is dialed once and shared across every
+	// batch. network.Bastion may list multiple hops (or network.ProxyJump
+	// may spell them out OpenSSH-`-J`-style as "user@jump1,user@jump2");
+	// each hop is dialed through the previous one, and only the innermost
+	// hop is handed to runBatch, since that's the one whose DialThrough
+	// reaches the real targets.
+	bastionHosts := network.Bastion
+	if len(bastionHosts) == 0 && network.ProxyJump != "" {
+		bastionHosts = parseProxyJump(network.ProxyJump)
+	}
+
 	var bastion *SSHClient
-	if network.Bastion != nil {
-		bastion = &SSHClient{
-			host:          network.Bastion,
-			password:      network.Password,
-			ignoreHostKey: sup.ignoreHostKey,
+	if len(bastionHosts) > 0 {
+		chain, err := buildBastionChain(bastionHosts, network.Password, sup.hostKeyPolicy)
+		if err != nil {
+			return nil, errors.Wrap(err, "connecting to bastion failed")
+		}
+		bastion = chain[len(chain)-1]
+		defer func() {
+			for i := len(chain) - 1; i >= 0; i-- {
+				chain[i].Close()
+			}
+		}()
+	}
+
+	result := &Result{}
+	failed := 0
+	for batchIdx, batch := range batchHosts(network.Hosts, network.Strategy, network.BatchSize) {
+		if network.MaxFailures > 0 && failed >= network.MaxFailures {
+			for _, host := range batch {
+				result.Hosts = append(result.Hosts, &HostResult{Host: host.Hostname, Status: HostSkipped})
+			}
+			continue
+		}
+
+		hostResults, err := sup.runBatch(batch, network, bastion, env, commands, structured, recorder)
+		if err != nil {
+			return nil, errors.Wrapf(err, "batch %d setup failed", batchIdx)
+		}
+
+		for _, hr := range hostResults {
+			if hr.Failed() {
+				failed++
+				hr.Status = HostFailed
+			} else {
+				hr.Status = HostSucceeded
+			}
+			result.Hosts = append(result.Hosts, hr)
 		}
-		if err := bastion.Connect(); err != nil {
-			return errors.Wrap(err, "connecting to bastion failed")
+	}
+
+	return result, nil
+}
+
+// connectedClient pairs a connected Client with the Hostname it was dialed
+// from, for HostResult.Host.
+type connectedClient struct {
+	client   Client
+	hostname string
+}
+
+// hostFailure builds the HostResult for a host that never produced a
+// Client (e.g. a connection failure).
+func (sup *Stackup) hostFailure(hostname, task string, err error) *HostResult {
+	sup.logger.Errorf("%v", err)
+	now := time.Now()
+	return &HostResult{
+		Host: hostname,
+		Commands: []*CommandResult{{
+			Host:      hostname,
+			Task:      task,
+			StartedAt: now,
+			EndedAt:   now,
+			ExitCode:  1,
+			Error:     err.Error(),
+		}},
+	}
+}
+
+// recordHostFailure folds a host-level failure (transfer, task-creation,
+// forward-setup, or dispatch error) into hr as a CommandResult.
+func (sup *Stackup) recordHostFailure(hr *HostResult, task string, err error, recorder *recordEncoder, structured bool) {
+	sup.logger.Errorf("%v", err)
+	now := time.Now()
+	result := &CommandResult{
+		Host:      hr.Host,
+		Task:      task,
+		StartedAt: now,
+		EndedAt:   now,
+		ExitCode:  1,
+		Error:     err.Error(),
+	}
+	hr.Commands = append(hr.Commands, result)
+	if structured {
+		if err := recorder.Encode(result); err != nil {
+			sup.logger.Warnf("%v", errors.Wrap(err, "writing structured output failed"))
 		}
 	}
+}
 
+// runBatch connects to one wave of hosts and runs every command on them,
+// returning the per-host results of that wave; splitting waves out of the
+// old single-wave Run is what makes a "rolling" Strategy possible.
+//
+// A host-level failure is folded into that host's HostResult and the
+// batch continues; the error return is reserved for failures before any
+// host work starts.
+func (sup *Stackup) runBatch(hosts []*Host, network *Network, bastion *SSHClient, env string, commands []*Command, structured bool, recorder *recordEncoder) ([]*HostResult, error) {
 	var wg sync.WaitGroup
-	clientCh := make(chan Client, len(network.Hosts))
-	errCh := make(chan error, len(network.Hosts))
+	clientCh := make(chan connectedClient, len(hosts))
+	failureCh := make(chan *HostResult, len(hosts))
 
-	for i, host := range network.Hosts {
+	for i, host := range hosts {
 		wg.Add(1)
 		go func(i int, host *Host) {
 			defer wg.Done()
@@ -131,10 +254,10 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 					env: env + `export SUP_HOST="` + host.Hostname + `";`,
 				}
 				if err := local.Connect(); err != nil {
-					errCh <- errors.Wrap(err, "connecting to localhost failed")
+					failureCh <- sup.hostFailure(host.Hostname, "connect", errors.Wrap(err, "connecting to localhost failed"))
 					return
 				}
-				clientCh <- local
+				clientCh <- connectedClient{client: local, hostname: host.Hostname}
 				return
 			}
 
@@ -148,58 +271,135 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 				host:          host,
 				password:      network.Password,
 				color:         Colors[i%len(Colors)],
-				ignoreHostKey: sup.ignoreHostKey,
+				hostKeyPolicy: sup.hostKeyPolicy,
 			}
 
+			sup.logger.Debugf("connecting to %s@%s (%d key(s) available)", host.User, host.Hostname, len(publicKeysSigners))
+			connectStart := time.Now()
+
 			if bastion != nil {
 				if err := remote.ConnectWith(bastion.DialThrough); err != nil {
-					errCh <- errors.Wrap(err, "connecting to remote host through bastion failed")
+					failureCh <- sup.hostFailure(host.Hostname, "connect", errors.Wrap(err, "connecting to remote host through bastion failed"))
 					return
 				}
 			} else {
 				if err := remote.Connect(); err != nil {
-					errCh <- errors.Wrap(err, "connecting to remote host failed")
+					failureCh <- sup.hostFailure(host.Hostname, "connect", errors.Wrap(err, "connecting to remote host failed"))
 					return
 				}
 			}
-			clientCh <- remote
+			sup.logger.Debugf("connected to %s in %s", host.Hostname, time.Since(connectStart))
+			clientCh <- connectedClient{client: remote, hostname: host.Hostname}
 		}(i, host)
 	}
 	wg.Wait()
 	close(clientCh)
-	close(errCh)
+	close(failureCh)
+
+	var batchResults []*HostResult
+	for hr := range failureCh {
+		batchResults = append(batchResults, hr)
+	}
 
 	maxLen := 0
 	var clients []Client
-	for client := range clientCh {
-		if remote, ok := client.(*SSHClient); ok {
+	hostResults := make(map[Client]*HostResult)
+	for cc := range clientCh {
+		if remote, ok := cc.client.(*SSHClient); ok {
 			defer remote.Close()
 		}
-		_, prefixLen := client.Prefix()
+		_, prefixLen := cc.client.Prefix()
 		if prefixLen > maxLen {
 			maxLen = prefixLen
 		}
-		clients = append(clients, client)
-	}
-	for err := range errCh {
-		return errors.Wrap(err, "connecting to clients failed")
+		clients = append(clients, cc.client)
+		hostResults[cc.client] = &HostResult{Host: cc.hostname}
 	}
 
+	// Clients dropped by a forward-setup or dispatch failure stay in
+	// hostResults but skip the I/O, wait, and signal loops below.
+	failedClients := make(map[Client]bool)
+
 	// Run command or run multiple commands defined by target sequentially.
 	for _, cmd := range commands {
+		// `upload:`/`download:` commands transfer files over SFTP instead of
+		// running a remote command, so they bypass task creation entirely.
+		if cmd.Upload != nil {
+			for c, err := range sup.runTransfer(cmd.Upload, true, clients) {
+				sup.recordHostFailure(hostResults[c], cmd.Name, errors.Wrap(err, "upload failed"), recorder, structured)
+			}
+			continue
+		}
+		if cmd.Download != nil {
+			for c, err := range sup.runTransfer(cmd.Download, false, clients) {
+				sup.recordHostFailure(hostResults[c], cmd.Name, errors.Wrap(err, "download failed"), recorder, structured)
+			}
+			continue
+		}
+
 		// Translate command into task(s).
 		tasks, err := sup.createTasks(cmd, clients, env)
 		if err != nil {
-			return errors.Wrap(err, "creating task failed")
+			// No client can run this command at all, so every client in
+			// the batch is charged with the failure, not just one host.
+			for _, c := range clients {
+				sup.recordHostFailure(hostResults[c], cmd.Name, errors.Wrap(err, "creating task failed"), recorder, structured)
+			}
+			continue
 		}
 
+		// Remote forwards declared on the network apply to every task run
+		// against it; ones declared on the command scope to this task only.
+		forwards := append(append([]RemoteForward{}, network.RemoteForwards...), cmd.RemoteForwards...)
+
 		// Run tasks sequentially.
 		for _, task := range tasks {
 			var writers []io.Writer
 			var wg sync.WaitGroup
 
-			// Run tasks on the provided clients.
+			// Per-client output buffers, only populated in structured mode
+			// (OutputJSON/OutputNDJSON), since text mode streams straight
+			// through to the terminal instead.
+			var stdoutBufs, stderrBufs map[Client]*bytes.Buffer
+			var bufMu sync.Mutex
+			startedAt := make(map[Client]time.Time)
+			if structured {
+				stdoutBufs = make(map[Client]*bytes.Buffer)
+				stderrBufs = make(map[Client]*bytes.Buffer)
+			}
+
+			// Open declared remote forwards before dispatching the task. A
+			// client whose forward fails to start sits out this task.
+			var forwarders []*remoteForwarder
 			for _, c := range task.Clients {
+				if failedClients[c] {
+					continue
+				}
+				remote, ok := c.(*SSHClient)
+				if !ok {
+					continue
+				}
+				for _, fw := range forwards {
+					rf, err := startRemoteForward(remote, fw, sup.logger)
+					if err != nil {
+						sup.recordHostFailure(hostResults[c], cmd.Name, errors.Wrap(err, "starting remote forward failed"), recorder, structured)
+						failedClients[c] = true
+						break
+					}
+					forwarders = append(forwarders, rf)
+				}
+			}
+
+			activeClients := make([]Client, 0, len(task.Clients))
+			for _, c := range task.Clients {
+				if !failedClients[c] {
+					activeClients = append(activeClients, c)
+				}
+			}
+
+			// Dispatch the task; a client whose dispatch fails drops out of
+			// this task's remaining I/O, wait, and signal handling.
+			for _, c := range activeClients {
 				var prefix string
 				var prefixLen int
 				if sup.prefix {
@@ -209,20 +409,31 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 					}
 				}
 
-				err := c.Run(task)
-				if err != nil {
-					return errors.Wrap(err, prefix+"task failed")
+				startedAt[c] = time.Now()
+				sup.logger.Infof("%sdispatching %q", prefix, task.Run)
+				if err := c.Run(task); err != nil {
+					sup.recordHostFailure(hostResults[c], cmd.Name, errors.Wrap(err, prefix+"task failed"), recorder, structured)
+					failedClients[c] = true
+					continue
 				}
 
 				// Copy over tasks's STDOUT.
 				wg.Add(1)
 				go func(c Client) {
 					defer wg.Done()
-					_, err := io.Copy(os.Stdout, prefixer.New(c.Stdout(), prefix))
+					var dst io.Writer = os.Stdout
+					if structured {
+						buf := &bytes.Buffer{}
+						bufMu.Lock()
+						stdoutBufs[c] = buf
+						bufMu.Unlock()
+						dst = buf
+					}
+					_, err := io.Copy(dst, prefixer.New(c.Stdout(), prefix))
 					if err != nil && err != io.EOF {
 						// TODO: io.Copy() should not return io.EOF at all.
 						// Upstream bug? Or prefixer.WriteTo() bug?
-						fmt.Fprintf(os.Stderr, "%v", errors.Wrap(err, prefix+"reading STDOUT failed"))
+						sup.logger.Warnf("%v", errors.Wrap(err, prefix+"reading STDOUT failed"))
 					}
 				}(c)
 
@@ -230,33 +441,57 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 				wg.Add(1)
 				go func(c Client) {
 					defer wg.Done()
-					_, err := io.Copy(os.Stderr, prefixer.New(c.Stderr(), prefix))
+					var dst io.Writer = os.Stderr
+					if structured {
+						buf := &bytes.Buffer{}
+						bufMu.Lock()
+						stderrBufs[c] = buf
+						bufMu.Unlock()
+						dst = buf
+					}
+					_, err := io.Copy(dst, prefixer.New(c.Stderr(), prefix))
 					if err != nil && err != io.EOF {
-						fmt.Fprintf(os.Stderr, "%v", errors.Wrap(err, prefix+"reading STDERR failed"))
+						sup.logger.Warnf("%v", errors.Wrap(err, prefix+"reading STDERR failed"))
 					}
 				}(c)
 
 				writers = append(writers, c.Stdin())
 			}
 
+			// Recompute the active set: dispatch may have just dropped
+			// some clients that were still active when forwards opened.
+			dispatched := make([]Client, 0, len(activeClients))
+			for _, c := range activeClients {
+				if !failedClients[c] {
+					dispatched = append(dispatched, c)
+				}
+			}
+
 			// Copy over task's STDIN.
-			if task.Input != nil {
+			if task.Input != nil && len(writers) > 0 {
 				go func() {
 					writer := io.MultiWriter(writers...)
 					_, err := io.Copy(writer, task.Input)
 					if err != nil && err != io.EOF {
-						fmt.Fprintf(os.Stderr, "%v", errors.Wrap(err, "copying STDIN failed"))
+						sup.logger.Warnf("%v", errors.Wrap(err, "copying STDIN failed"))
 					}
 					// TODO: Use MultiWriteCloser (not in Stdlib), so we can writer.Close() instead?
-					for _, c := range clients {
+					for _, c := range dispatched {
 						c.WriteClose()
 					}
 				}()
 			}
 
-			// Catch OS signals and pass them to all active clients.
+			// Catch OS signals and pass them to all active clients, same as
+			// os.Interrupt. SIGHUP additionally tears the task's remote
+			// forwards down first -- and rf.Close() blocks until every
+			// in-flight forwarded connection drains -- before the signal is
+			// forwarded on, so a forward doesn't outlive the disconnect
+			// that's about to follow. This doesn't make Run or the task
+			// exit early; that's still up to the client on the other end
+			// of the signal.
 			trap := make(chan os.Signal, 1)
-			signal.Notify(trap, os.Interrupt)
+			signal.Notify(trap, os.Interrupt, syscall.SIGHUP)
 			go func() {
 				for {
 					select {
@@ -264,10 +499,17 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 						if !ok {
 							return
 						}
-						for _, c := range task.Clients {
+						if sig == syscall.SIGHUP {
+							for _, rf := range forwarders {
+								if err := rf.Close(); err != nil {
+									sup.logger.Warnf("%v", errors.Wrap(err, "closing remote forward failed"))
+								}
+							}
+						}
+						for _, c := range dispatched {
 							err := c.Signal(sig)
 							if err != nil {
-								fmt.Fprintf(os.Stderr, "%v", errors.Wrap(err, "sending signal failed"))
+								sup.logger.Warnf("%v", errors.Wrap(err, "sending signal failed"))
 							}
 						}
 					}
@@ -277,11 +519,16 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 			// Wait for all I/O operations first.
 			wg.Wait()
 
-			// Make sure each client finishes the task, return on failure.
-			for _, c := range task.Clients {
+			// Wait for each dispatched client to finish, recording its exit
+			// status instead of exiting the process immediately.
+			var resMu sync.Mutex
+			for _, c := range dispatched {
 				wg.Add(1)
 				go func(c Client) {
 					defer wg.Done()
+
+					exitCode := 0
+					errMsg := ""
 					if err := c.Wait(); err != nil {
 						var prefix string
 						if sup.prefix {
@@ -292,14 +539,51 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 							}
 						}
 						if e, ok := err.(*ssh.ExitError); ok && e.ExitStatus() != 15 {
-							// TODO: Store all the errors, and print them after Wait().
-							fmt.Fprintf(os.Stderr, "%s%v\n", prefix, e)
-							os.Exit(e.ExitStatus())
+							exitCode = e.ExitStatus()
+						} else {
+							exitCode = 1
 						}
-						fmt.Fprintf(os.Stderr, "%s%v\n", prefix, err)
+						errMsg = err.Error()
+						if !structured {
+							sup.logger.Errorf("%s%v", prefix, err)
+						}
+					} else {
+						hostPrefix, _ := c.Prefix()
+						sup.logger.Infof("%sfinished %q in %s", hostPrefix, task.Run, time.Since(startedAt[c]))
+					}
 
-						// TODO: Shouldn't os.Exit(1) here. Instead, collect the exit statuses for later.
-						os.Exit(1)
+					var stdout, stderr string
+					if structured {
+						bufMu.Lock()
+						if buf, ok := stdoutBufs[c]; ok {
+							stdout = buf.String()
+						}
+						if buf, ok := stderrBufs[c]; ok {
+							stderr = buf.String()
+						}
+						bufMu.Unlock()
+					}
+
+					result := &CommandResult{
+						Host:      hostResults[c].Host,
+						Task:      cmd.Name,
+						Command:   task.Run,
+						Stdout:    stdout,
+						Stderr:    stderr,
+						StartedAt: startedAt[c],
+						EndedAt:   time.Now(),
+						ExitCode:  exitCode,
+						Error:     errMsg,
+					}
+
+					resMu.Lock()
+					hostResults[c].Commands = append(hostResults[c].Commands, result)
+					resMu.Unlock()
+
+					if structured {
+						if err := recorder.Encode(result); err != nil {
+							sup.logger.Warnf("%v", errors.Wrap(err, "writing structured output failed"))
+						}
 					}
 				}(c)
 			}
@@ -310,20 +594,73 @@ func (sup *Stackup) Run(network *Network, envVars EnvList, commands ...*Command)
 			// Stop catching signals for the currently active clients.
 			signal.Stop(trap)
 			close(trap)
+
+			// Tear the forwards down now that the task is done; Close is
+			// idempotent, so this is harmless if SIGHUP already drained
+			// them above.
+			for _, rf := range forwarders {
+				if err := rf.Close(); err != nil {
+					sup.logger.Warnf("%v", errors.Wrap(err, "closing remote forward failed"))
+				}
+			}
 		}
 	}
 
-	return nil
+	for _, c := range clients {
+		batchResults = append(batchResults, hostResults[c])
+	}
+	return batchResults, nil
 }
 
+// Debug toggles Debug-level logging (per-host connection attempts with the
+// number of available keys, and connection timing); command dispatch and
+// finish lines are logged at Info level either way. Previously this flag
+// was stored but never consulted; it now raises or lowers the default
+// logger's level.
 func (sup *Stackup) Debug(value bool) {
 	sup.debug = value
+	if dl, ok := sup.logger.(*logrusLogger); ok {
+		if value {
+			dl.SetLevel(logrus.DebugLevel)
+		} else {
+			dl.SetLevel(logrus.InfoLevel)
+		}
+	}
 }
 
 func (sup *Stackup) Prefix(value bool) {
 	sup.prefix = value
 }
 
+// OutputFormat selects how Run reports command output: "text" (default)
+// streams raw output to the terminal, while "json"/"ndjson" stream one
+// structured CommandResult record per command to the writer set via
+// SetOutput (os.Stdout by default).
+func (sup *Stackup) OutputFormat(format string) {
+	sup.format = OutputFormat(format)
+}
+
+// SetOutput sets the writer structured ("json"/"ndjson") output records are
+// streamed to. Defaults to os.Stdout.
+func (sup *Stackup) SetOutput(w io.Writer) {
+	sup.output = w
+}
+
+// IgnoreHostKey is kept for backward compatibility with the previous
+// all-or-nothing toggle; prefer HostKeyPolicy, which also supports "strict"
+// (the default), "tofu", and "accept-new".
 func (sup *Stackup) IgnoreHostKey(value bool) {
-	sup.ignoreHostKey = value
+	if value {
+		sup.hostKeyPolicy = HostKeyIgnore
+	} else {
+		sup.hostKeyPolicy = HostKeyStrict
+	}
+}
+
+// HostKeyPolicy selects how host keys are verified: "strict" (the default)
+// requires the key to already be in known_hosts; "tofu"/"accept-new" trust
+// and record an unknown key on first contact but hard-fail on a later
+// mismatch; "ignore" skips verification entirely.
+func (sup *Stackup) HostKeyPolicy(policy string) {
+	sup.hostKeyPolicy = HostKeyPolicy(policy)
 }